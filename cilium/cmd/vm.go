@@ -0,0 +1,171 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cilium/cilium/pkg/vmmanager"
+)
+
+// vmManagerAddr is the address of the clustermesh-apiserver VM registration
+// API that `cilium vm` sends its requests to, e.g. reached from outside the
+// cluster via a port-forward.
+var vmManagerAddr string
+
+var vmCmd = &cobra.Command{
+	Use:   "vm",
+	Short: "Manage external VM workloads registered with the cluster",
+}
+
+var vmListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered VM workloads",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var vms []*vmmanager.VM
+		if err := vmAPIRequest(http.MethodGet, "/list", nil, &vms); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tIDENTITY\tIPS")
+		for _, vm := range vms {
+			ips := make([]string, 0, len(vm.IPs))
+			for _, ip := range vm.IPs {
+				ips = append(ips, ip.String())
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", vm.Name, vm.Identity, strings.Join(ips, ","))
+		}
+		return w.Flush()
+	},
+}
+
+var (
+	vmAddLabels []string
+	vmAddToken  string
+)
+
+var vmAddCmd = &cobra.Command{
+	Use:   "add <name> <ip> [ip...]",
+	Short: "Register a VM workload",
+	Long: "Register a VM workload. This only records the registration with " +
+		"clustermesh-apiserver; it does not itself send heartbeats, so the " +
+		"VM will be garbage collected after vmmanager.DefaultHeartbeatTimeout " +
+		"unless vm-agent is run on the VM to keep the registration alive.",
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := vmmanager.RegisterRequest{
+			Name:   args[0],
+			IPs:    args[1:],
+			Labels: parseLabels(vmAddLabels),
+			Token:  vmAddToken,
+		}
+
+		var vm vmmanager.VM
+		if err := vmAPIRequest(http.MethodPost, "/register", req, &vm); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Registered VM %q with identity %d\n", vm.Name, vm.Identity)
+		return nil
+	},
+}
+
+// parseLabels converts "key=value" flag values into a label map, ignoring
+// any entry that isn't of that form.
+func parseLabels(kvs []string) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	lbls := map[string]string{}
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		lbls[parts[0]] = parts[1]
+	}
+	return lbls
+}
+
+var vmRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a registered VM workload",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := vmAPIRequest(http.MethodDelete, "/remove?name="+url.QueryEscape(args[0]), nil, nil); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Removed VM %q\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	vmListCmd.Flags().StringVar(&vmManagerAddr, "vm-manager-address", "http://localhost:9999", "Address of the clustermesh-apiserver VM registration API")
+	vmAddCmd.Flags().StringVar(&vmManagerAddr, "vm-manager-address", "http://localhost:9999", "Address of the clustermesh-apiserver VM registration API")
+	vmRemoveCmd.Flags().StringVar(&vmManagerAddr, "vm-manager-address", "http://localhost:9999", "Address of the clustermesh-apiserver VM registration API")
+
+	vmAddCmd.Flags().StringSliceVar(&vmAddLabels, "labels", nil, "Labels to register the VM with, e.g. --labels app=frontend,env=prod")
+	vmAddCmd.Flags().StringVar(&vmAddToken, "token", "", "Pre-shared token the VM must present on heartbeats")
+
+	vmCmd.AddCommand(vmListCmd, vmAddCmd, vmRemoveCmd)
+	RootCmd.AddCommand(vmCmd)
+}
+
+// vmAPIRequest issues an HTTP request against the VM registration API and
+// decodes the JSON response into out, if non-nil.
+func vmAPIRequest(method, path string, body, out interface{}) error {
+	var reqBody *bytes.Buffer
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(b)
+	} else {
+		reqBody = bytes.NewBuffer(nil)
+	}
+
+	req, err := http.NewRequest(method, vmManagerAddr+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach VM registration API at %s: %w", vmManagerAddr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("VM registration API returned %s", resp.Status)
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
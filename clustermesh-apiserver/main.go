@@ -0,0 +1,56 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// clustermesh-apiserver exposes cluster-mesh-facing APIs that do not belong
+// in the per-node Cilium agent, starting with VM registration.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/vmmanager"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "clustermesh-apiserver")
+
+func main() {
+	vmManagerAddr := flag.String("vm-manager-address", ":9999", "Address the VM registration API listens on")
+	vmHeartbeatTimeout := flag.Duration("vm-heartbeat-timeout", vmmanager.DefaultHeartbeatTimeout, "Time after which a VM that has not sent a heartbeat is garbage collected")
+	flag.Parse()
+
+	manager := vmmanager.NewManager(*vmHeartbeatTimeout)
+
+	go pruneStaleVMsPeriodically(manager)
+
+	log.WithField(logfields.Address, *vmManagerAddr).Info("Starting VM registration API")
+	if err := http.ListenAndServe(*vmManagerAddr, vmmanager.NewHandler(manager)); err != nil {
+		log.WithError(err).Fatal("VM registration API server exited")
+	}
+}
+
+// pruneStaleVMsPeriodically runs Manager.PruneStale every 30 seconds,
+// independent of the configured heartbeat timeout. The Cilium agent picks
+// up the removals on its next reconcile of the VM list.
+func pruneStaleVMsPeriodically(manager *vmmanager.Manager) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		manager.PruneStale()
+	}
+}
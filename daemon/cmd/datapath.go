@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -31,6 +32,7 @@ import (
 	"github.com/cilium/cilium/pkg/identity"
 	"github.com/cilium/cilium/pkg/ipcache"
 	"github.com/cilium/cilium/pkg/labels"
+	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/maps/ctmap"
 	"github.com/cilium/cilium/pkg/maps/egressmap"
@@ -144,12 +146,38 @@ func (d *Daemon) SetPrefilter(preFilter datapath.PreFilter) {
 // filesystem for removing maps related to endpoints from the filesystem.
 type EndpointMapManager struct {
 	*endpointmanager.EndpointManager
+
+	// mutex protects spiffeSubscriptions.
+	mutex lock.Mutex
+
+	// spiffeSubscriptions holds the cancel functions of the running SVID
+	// watches started by Daemon.subscribeEndpointSPIFFE, keyed by
+	// endpoint ID. It is nil unless spiffe.enabled is set.
+	spiffeSubscriptions map[uint16]context.CancelFunc
+
+	// spiffeControllers is the single controller.Manager shared by
+	// Daemon.subscribeEndpointSPIFFE and RemoveDatapathMapping, so that
+	// controllers registered for an endpoint's SVID watch can actually be
+	// found and stopped again on removal.
+	spiffeControllers *controller.Manager
 }
 
 // RemoveDatapathMapping unlinks the endpointID from the global policy map, preventing
 // packets that arrive on this node from being forwarded to the endpoint that
 // used to exist with the specified ID.
 func (e *EndpointMapManager) RemoveDatapathMapping(endpointID uint16) error {
+	e.mutex.Lock()
+	if cancel, ok := e.spiffeSubscriptions[endpointID]; ok {
+		cancel()
+		delete(e.spiffeSubscriptions, endpointID)
+	}
+	controllers := e.spiffeControllers
+	e.mutex.Unlock()
+
+	if controllers != nil {
+		controllers.RemoveController(spiffeControllerName(endpointID))
+	}
+
 	return policymap.RemoveGlobalMapping(uint32(endpointID))
 }
 
@@ -347,8 +375,13 @@ func (d *Daemon) initMaps() error {
 		return err
 	}
 
+	if err := d.initSpiffe(); err != nil {
+		return err
+	}
+
 	for _, ep := range d.endpointManager.GetEndpoints() {
 		ep.InitMap()
+		d.subscribeEndpointSPIFFE(ep.GetID16(), endpointAddresses(ep))
 	}
 
 	for _, ep := range d.endpointManager.GetEndpoints() {
@@ -417,6 +450,10 @@ func (d *Daemon) initMaps() error {
 			Context:     d.ctx,
 		})
 
+	// Start the controller that reconciles external VM workloads registered
+	// with clustermesh-apiserver into lxcmap/ipcache, if enabled.
+	d.initVMManager()
+
 	if !option.Config.RestoreState {
 		// If we are not restoring state, all endpoints can be
 		// deleted. Entries will be re-populated.
@@ -0,0 +1,147 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+
+	"github.com/cilium/cilium/pkg/controller"
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/ipcache"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/source"
+	"github.com/cilium/cilium/pkg/spiffe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// spiffeControllerName returns the controller name used to watch and renew
+// the SVID for the given endpoint.
+func spiffeControllerName(endpointID uint16) string {
+	return fmt.Sprintf("spiffe-svid-watcher-%d", endpointID)
+}
+
+// endpointAddresses returns every address owned by ep, v4 and v6 alike, so
+// that IPv6-only endpoints get their SPIFFE labels upserted too instead of
+// being silently skipped.
+func endpointAddresses(ep spiffeEndpoint) []string {
+	var ips []string
+	if ip := ep.GetIPv4Address(); ip != "" {
+		ips = append(ips, ip)
+	}
+	if ip := ep.GetIPv6Address(); ip != "" {
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// spiffeEndpoint is the subset of *endpoint.Endpoint that
+// endpointAddresses needs.
+type spiffeEndpoint interface {
+	GetIPv4Address() string
+	GetIPv6Address() string
+}
+
+// initSpiffe dials the local spire-agent Workload API, if spiffe.enabled is
+// set, so that syncEndpointsAndHostIPs and subsequent endpoint regenerations
+// can derive SPIFFE labels for local endpoints.
+func (d *Daemon) initSpiffe() error {
+	if !option.Config.EnableSPIFFE {
+		return nil
+	}
+
+	client, err := spiffe.NewClient(d.ctx, option.Config.SPIFFEAgentSocketPath)
+	if err != nil {
+		return fmt.Errorf("unable to initialize SPIFFE Workload API client: %w", err)
+	}
+
+	d.spiffeClient = client
+	d.endpointMapManager.spiffeSubscriptions = map[uint16]context.CancelFunc{}
+	d.endpointMapManager.spiffeControllers = controller.NewManager()
+	return nil
+}
+
+// subscribeEndpointSPIFFE starts (or restarts) a controller that watches the
+// Workload API for SVID updates on behalf of endpointID and upserts the
+// derived SPIFFE labels into the identity/ipcache, for every address owned
+// by the endpoint, so that they become available to
+// `toEndpoints`/`fromEndpoints` policy selectors, in addition to the
+// endpoint's existing Kubernetes labels. The watch, and the controller
+// driving it, are cancelled when the endpoint is removed via
+// EndpointMapManager.RemoveDatapathMapping.
+func (d *Daemon) subscribeEndpointSPIFFE(endpointID uint16, ips []string) {
+	if d.spiffeClient == nil || len(ips) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(d.ctx)
+
+	d.endpointMapManager.mutex.Lock()
+	if existing, ok := d.endpointMapManager.spiffeSubscriptions[endpointID]; ok {
+		existing()
+	}
+	d.endpointMapManager.spiffeSubscriptions[endpointID] = cancel
+	controllers := d.endpointMapManager.spiffeControllers
+	d.endpointMapManager.mutex.Unlock()
+
+	controllers.UpdateController(spiffeControllerName(endpointID),
+		controller.ControllerParams{
+			DoFunc: func(_ context.Context) error {
+				return d.spiffeClient.WatchSVIDs(ctx, func(svid *x509svid.SVID) {
+					for _, ip := range ips {
+						d.updateSPIFFELabels(ip, svid)
+					}
+				})
+			},
+			Context: ctx,
+		})
+}
+
+// updateSPIFFELabels re-derives the SPIFFE labels for the given SVID, merges
+// them with any k8s labels already known for ip, allocates (or looks up) the
+// identity corresponding to the merged label set, and upserts that identity
+// into the ipcache entry for ip. The merge happens on a fresh label map
+// rather than mutating the map returned by GetK8sMetadata, which other
+// goroutines may be reading concurrently.
+func (d *Daemon) updateSPIFFELabels(ip string, svid *x509svid.SVID) {
+	id := spiffe.IDFromSVID(svid)
+	lbls := spiffe.LabelsFromID(id)
+
+	log.WithFields(logrus.Fields{
+		logfields.IPAddr:   ip,
+		logfields.Identity: id.String(),
+	}).Debug("Updating SPIFFE labels for endpoint")
+
+	k8sMeta := ipcache.IPIdentityCache.GetK8sMetadata(ip)
+	if k8sMeta != nil {
+		lbls.MergeLabels(k8sMeta.IdentityLabels)
+	}
+
+	allocated, _, err := d.identityAllocator.AllocateIdentity(d.ctx, lbls, true, identity.InvalidIdentity)
+	if err != nil {
+		log.WithError(err).WithField(logfields.IPAddr, ip).Warning("Unable to allocate identity for SPIFFE labels")
+		return
+	}
+
+	ipcache.IPIdentityCache.Upsert(ip, nil, node.GetIPsecKeyIdentity(), k8sMeta, ipcache.Identity{
+		ID:     allocated.ID,
+		Source: source.SPIFFE,
+	})
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/cilium/cilium/pkg/controller"
+	"github.com/cilium/cilium/pkg/ipcache"
+	"github.com/cilium/cilium/pkg/labels"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/maps/lxcmap"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/source"
+	"github.com/cilium/cilium/pkg/vmmanager"
+
+	"github.com/sirupsen/logrus"
+)
+
+// vmReconcilerControllerName is the controller that periodically reconciles
+// the set of VMs known to clustermesh-apiserver into lxcmap/ipcache.
+const vmReconcilerControllerName = "vmmanager-reconciler"
+
+// initVMManager dials the VM registration API exposed by
+// clustermesh-apiserver, if option.Config.EnableVMManager is set, and starts
+// a controller that periodically reconciles the registered VMs into
+// lxcmap/ipcache. The agent deliberately does not run its own
+// vmmanager.Manager: identities are allocated once, cluster-wide, by
+// clustermesh-apiserver, so every node converges on the same identity for a
+// given VM instead of racing its own per-node allocator.
+func (d *Daemon) initVMManager() {
+	if !option.Config.EnableVMManager {
+		return
+	}
+
+	d.vmManagerClient = vmmanager.NewClient(option.Config.VMManagerAddress)
+	d.vmManagerSeen = map[string]*vmmanager.VM{}
+
+	controller.NewManager().UpdateController(vmReconcilerControllerName,
+		controller.ControllerParams{
+			DoFunc:      d.reconcileVMs,
+			RunInterval: 30 * time.Second,
+			Context:     d.ctx,
+		})
+}
+
+// reconcileVMs fetches the current VM registry from clustermesh-apiserver
+// and diffs it against the VMs seen on the previous run, calling VMUpserted
+// for new or changed VMs and VMRemoved for VMs that have disappeared (either
+// explicitly removed, or garbage collected there after missing heartbeats).
+// The diff considers both identity and IP set changes: if a VM keeps its
+// name and identity but drops an IP, the dropped IP's lxcmap/ipcache entry
+// is deleted before the remaining IPs are re-upserted, instead of being
+// left behind as a stale mapping.
+func (d *Daemon) reconcileVMs(ctx context.Context) error {
+	vms, err := d.vmManagerClient.List(ctx)
+	if err != nil {
+		log.WithError(err).Warning("Unable to list VMs from clustermesh-apiserver")
+		return err
+	}
+
+	seen := make(map[string]*vmmanager.VM, len(vms))
+	for _, vm := range vms {
+		seen[vm.Name] = vm
+
+		old, ok := d.vmManagerSeen[vm.Name]
+		if !ok {
+			d.VMUpserted(vm)
+			continue
+		}
+
+		if old.Identity == vm.Identity && sameIPs(old.IPs, vm.IPs) {
+			continue
+		}
+
+		for _, ip := range removedIPs(old.IPs, vm.IPs) {
+			d.deleteVMIP(ip, old.Name)
+		}
+		d.VMUpserted(vm)
+	}
+
+	for name, old := range d.vmManagerSeen {
+		if _, ok := seen[name]; !ok {
+			d.VMRemoved(old)
+		}
+	}
+
+	d.vmManagerSeen = seen
+	return nil
+}
+
+// sameIPs reports whether a and b contain the same set of IP addresses,
+// ignoring order.
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(removedIPs(a, b)) == 0 && len(removedIPs(b, a)) == 0
+}
+
+// removedIPs returns the IPs present in old but not in new.
+func removedIPs(old, new []net.IP) []net.IP {
+	keep := make(map[string]struct{}, len(new))
+	for _, ip := range new {
+		keep[ip.String()] = struct{}{}
+	}
+
+	var removed []net.IP
+	for _, ip := range old {
+		if _, ok := keep[ip.String()]; !ok {
+			removed = append(removed, ip)
+		}
+	}
+	return removed
+}
+
+// vmLabels converts a VM's labels into Cilium labels sourced as
+// source.ExternalWorkload, the same way spiffe.LabelsFromID sources SPIFFE
+// labels, so that CiliumNetworkPolicy selectors can match on them.
+func vmLabels(vm *vmmanager.VM) labels.Labels {
+	return labels.Map2Labels(vm.Labels, labels.LabelSourceExternalWorkload)
+}
+
+// VMUpserted implements vmmanager.Listener. It registers the VM's labels
+// with the identity allocator, preferring to restore the identity
+// clustermesh-apiserver already allocated for this label set (vm.Identity)
+// so that the ipcache identity and the real allocator's view of it agree,
+// and writes the result into lxcmap and ipcache for each of the VM's IP
+// addresses, with source.ExternalWorkload, the same way syncEndpointsAndHostIPs
+// writes special identities for local host IPs.
+func (d *Daemon) VMUpserted(vm *vmmanager.VM) {
+	allocated, _, err := d.identityAllocator.AllocateIdentity(d.ctx, vmLabels(vm), true, vm.Identity)
+	if err != nil {
+		log.WithError(err).WithField(logfields.Identity, vm.Name).Warning("Unable to allocate identity for VM labels")
+		return
+	}
+
+	for _, ip := range vm.IPs {
+		if _, err := lxcmap.SyncHostEntry(ip); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				logfields.IPAddr: ip,
+			}).Warning("Unable to add VM entry to endpoint map")
+			continue
+		}
+
+		ipcache.IPIdentityCache.Upsert(ip.String(), nil, 0, nil, ipcache.Identity{
+			ID:     allocated.ID,
+			Source: source.ExternalWorkload,
+		})
+	}
+
+	log.WithFields(logrus.Fields{
+		logfields.Identity: allocated.ID,
+	}).Infof("Registered VM %q with %d address(es)", vm.Name, len(vm.IPs))
+}
+
+// VMRemoved implements vmmanager.Listener. It removes the lxcmap/ipcache
+// entries for the VM's addresses, tombstoning the stale entries the same
+// way obsolete host IPs are pruned in syncEndpointsAndHostIPs.
+func (d *Daemon) VMRemoved(vm *vmmanager.VM) {
+	for _, ip := range vm.IPs {
+		d.deleteVMIP(ip, vm.Name)
+	}
+
+	log.Infof("Removed VM %q", vm.Name)
+}
+
+// deleteVMIP removes the lxcmap/ipcache entry for a single VM IP. It is
+// shared by VMRemoved and reconcileVMs, which also needs to delete
+// individual IPs a VM has stopped advertising without removing the VM
+// itself.
+func (d *Daemon) deleteVMIP(ip net.IP, vmName string) {
+	if err := lxcmap.DeleteEntry(ip); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			logfields.IPAddr: ip,
+		}).Warningf("Unable to delete stale VM entry for %q from endpoint map", vmName)
+	}
+
+	ipcache.IPIdentityCache.Delete(ip.String(), source.ExternalWorkload)
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package identity
+
+// MinExternalWorkloadIdentity and MaxExternalWorkloadIdentity bound the
+// numeric identity range reserved for external workloads registered via
+// pkg/vmmanager (VMs and other non-agent workloads that join the cluster
+// mesh without running the full Cilium agent). The range is carved out of
+// the local identity space so that it is never handed out by the
+// kvstore/CRD-backed allocators used for pod identities.
+const (
+	MinExternalWorkloadIdentity = NumericIdentity(128000)
+	MaxExternalWorkloadIdentity = NumericIdentity(132000)
+)
@@ -0,0 +1,22 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+// LabelSourceExternalWorkload is the source used for labels attached to an
+// external workload (see pkg/vmmanager), i.e. a VM or bare-metal host that
+// registered itself against clustermesh-apiserver rather than running the
+// full Cilium agent. It matches source.ExternalWorkload so that the two
+// packages agree on how these labels are named.
+const LabelSourceExternalWorkload = "external-workload"
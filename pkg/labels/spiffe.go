@@ -0,0 +1,24 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+// LabelSourceSpiffe is the source used for labels derived from a SPIFFE ID
+// fetched over the Workload API (see pkg/spiffe). There is no dedicated
+// parser-side handling for it: `CiliumNetworkPolicy` selectors of the form
+// `spiffe:id: spiffe://...` match these labels only because selectors are
+// parsed generically as `source:key=value` and spiffe.LabelsFromID happens
+// to use this same "spiffe" string as both the label source and the `spiffe:`
+// prefix operators write in their selectors.
+const LabelSourceSpiffe = "spiffe"
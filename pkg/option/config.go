@@ -0,0 +1,139 @@
+// Copyright 2016-2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package option holds the daemon's runtime configuration, populated from
+// command-line flags and the environment.
+package option
+
+import (
+	"net"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// NodePortAlgMaglev selects the Maglev consistent-hashing backend
+	// selection algorithm for NodePort/LoadBalancer services.
+	NodePortAlgMaglev = "maglev"
+
+	// SPIFFEEnabled enables fetching SVIDs from a local spire-agent and
+	// deriving SPIFFE labels for local endpoints from them.
+	SPIFFEEnabled = "spiffe-enabled"
+
+	// SPIFFEAgentSocketPath sets the Workload API Unix socket used to
+	// reach the local spire-agent.
+	SPIFFEAgentSocketPath = "spiffe-agent-socket-path"
+
+	// VMManagerEnabled enables reconciling external VM workloads
+	// registered with clustermesh-apiserver into lxcmap/ipcache.
+	VMManagerEnabled = "vm-manager-enabled"
+
+	// VMManagerAddress sets the address of the clustermesh-apiserver VM
+	// registration API the agent reconciles against.
+	VMManagerAddress = "vm-manager-address"
+)
+
+// DaemonConfig holds the runtime configuration derived from command-line
+// flags and the environment.
+type DaemonConfig struct {
+	DryMode bool
+
+	EnableIPv4 bool
+	EnableIPv6 bool
+
+	EnableHostReachableServices bool
+	EnableHostServicesUDP       bool
+
+	EnableIPMasqAgent bool
+	EnableIPSec       bool
+	EncryptNode       bool
+	IPSecKeyFile      string
+
+	EnableNodePort            bool
+	EnableSVCSourceRangeCheck bool
+	EnableSessionAffinity     bool
+	NodePortAlg               string
+	MaglevTableSize           int
+
+	EnableIPv4FragmentsTracking bool
+	FragmentsMapEntries         int
+
+	ExternalWorkload bool
+	RestoreState     bool
+	HostDevice       string
+
+	// EnableSPIFFE turns on the SPIFFE identity source: when set, the
+	// daemon fetches SVIDs for local endpoints from a spire-agent and
+	// upserts the derived labels into the identity/ipcache.
+	EnableSPIFFE bool
+
+	// SPIFFEAgentSocketPath is the Unix socket of the local spire-agent's
+	// Workload API. Defaults to spiffe.DefaultAgentSocketPath when empty.
+	SPIFFEAgentSocketPath string
+
+	// EnableVMManager turns on reconciliation of external VM workloads:
+	// when set, the daemon periodically fetches the VM registry from
+	// clustermesh-apiserver and mirrors it into lxcmap/ipcache.
+	EnableVMManager bool
+
+	// VMManagerAddress is the address of the clustermesh-apiserver VM
+	// registration API, e.g. "http://clustermesh-apiserver.kube-system.svc:9999".
+	VMManagerAddress string
+
+	excludedLocalAddresses []net.IPNet
+}
+
+// Config is the global daemon configuration, populated by Populate().
+var Config = &DaemonConfig{}
+
+// IsExcludedLocalAddress returns true if the given IP has been explicitly
+// excluded from consideration as a local address, e.g. via
+// --exclude-local-address.
+func (c *DaemonConfig) IsExcludedLocalAddress(ip net.IP) bool {
+	for _, ipnet := range c.excludedLocalAddresses {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNodeConfigPath returns the path to the node configuration header file
+// consumed by the datapath.
+func (c *DaemonConfig) GetNodeConfigPath() string {
+	return filepath.Join("/var/run/cilium/state", "node_config.h")
+}
+
+// Flags registers the command-line flags backing this series' additions.
+// Flags for the rest of DaemonConfig are registered alongside the other
+// daemon flags.
+func Flags(flags *pflag.FlagSet) {
+	flags.Bool(SPIFFEEnabled, false, "Fetch SVIDs from the local spire-agent and derive SPIFFE labels for local endpoints")
+	flags.String(SPIFFEAgentSocketPath, "", "Unix socket path of the local spire-agent Workload API")
+
+	flags.Bool(VMManagerEnabled, false, "Reconcile external VM workloads registered with clustermesh-apiserver into lxcmap/ipcache")
+	flags.String(VMManagerAddress, "", "Address of the clustermesh-apiserver VM registration API")
+}
+
+// Populate reads this series' flags out of vp into c. It is called from the
+// daemon's flag-populate step alongside the rest of DaemonConfig.
+func (c *DaemonConfig) Populate(vp *viper.Viper) {
+	c.EnableSPIFFE = vp.GetBool(SPIFFEEnabled)
+	c.SPIFFEAgentSocketPath = vp.GetString(SPIFFEAgentSocketPath)
+
+	c.EnableVMManager = vp.GetBool(VMManagerEnabled)
+	c.VMManagerAddress = vp.GetString(VMManagerAddress)
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package source defines the source of an identity/ipcache entry, and the
+// precedence rules used to decide whether a new entry may overwrite an
+// existing one.
+package source
+
+// Source describes the source of a definition
+type Source string
+
+const (
+	// Unspec is used when the source of an object is unspecified
+	Unspec Source = ""
+
+	// KVStore describes a source based on data in the KVStore
+	KVStore Source = "kvstore"
+
+	// Kubernetes describes a source based on Kubernetes resources
+	Kubernetes Source = "kubernetes"
+
+	// CustomResource describes a source based on a Kubernetes custom resource
+	CustomResource Source = "custom-resource"
+
+	// LocalAPI describes a source based on the local API
+	LocalAPI Source = "api"
+
+	// Generated describes a source based on internal generation
+	Generated Source = "generated"
+
+	// Local describes a source based on local features
+	Local Source = "local"
+
+	// SPIFFE describes a source based on a SPIFFE Workload API identity,
+	// i.e. a SVID fetched from a spire-agent over the Workload API. It
+	// ranks alongside Kubernetes rather than above it: updateSPIFFELabels
+	// only re-derives labels on SVID rotation, not on Kubernetes label
+	// changes, so if SPIFFE outranked Kubernetes a pod's identity could
+	// get stuck on stale SPIFFE-derived labels between rotations. Ranking
+	// them equally lets whichever source last observed a change win.
+	SPIFFE Source = "spiffe"
+
+	// ExternalWorkload describes a source based on an external workload,
+	// i.e. a VM or bare-metal host that registered itself against
+	// clustermesh-apiserver via pkg/vmmanager rather than running the full
+	// Cilium agent.
+	ExternalWorkload Source = "external-workload"
+)
+
+// AllowOverwrite returns true if new can overwrite existing as a source.
+// This function is used in refcount-aware calculations to determine whether
+// the change of a source for a given IP is valid.
+func AllowOverwrite(existing, new Source) bool {
+	switch existing {
+	case LocalAPI:
+		return new == LocalAPI
+	case Local:
+		return true
+	default:
+		rank := map[Source]int{
+			Unspec:           0,
+			KVStore:          1,
+			Kubernetes:       1,
+			CustomResource:   1,
+			Generated:        1,
+			ExternalWorkload: 1,
+			SPIFFE:           1,
+			Local:            2,
+			LocalAPI:         3,
+		}
+		return rank[new] >= rank[existing]
+	}
+}
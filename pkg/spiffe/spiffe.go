@@ -0,0 +1,127 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spiffe provides a thin wrapper around the SPIFFE Workload API
+// (https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE_Workload_API.md)
+// used to fetch X.509 SVIDs for local endpoints from a spire-agent over a
+// Unix domain socket, and to translate SPIFFE IDs into Cilium labels so
+// that they can participate in identity allocation and policy selection.
+package spiffe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+
+	"github.com/cilium/cilium/pkg/labels"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "spiffe")
+
+const (
+	// DefaultAgentSocketPath is the default path of the spire-agent
+	// Workload API Unix domain socket, matching the upstream spire-agent
+	// helm chart default.
+	DefaultAgentSocketPath = "unix:///run/spire/sockets/agent.sock"
+)
+
+// Client wraps a workloadapi.Client configured to talk to a local
+// spire-agent over its Workload API Unix socket.
+type Client struct {
+	inner *workloadapi.Client
+}
+
+// NewClient dials the spire-agent Workload API at socketPath. socketPath
+// must be a `unix://` URI, as required by the Workload API spec.
+func NewClient(ctx context.Context, socketPath string) (*Client, error) {
+	if socketPath == "" {
+		socketPath = DefaultAgentSocketPath
+	}
+
+	c, err := workloadapi.New(ctx, workloadapi.WithAddr(socketPath))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to spire-agent at %s: %w", socketPath, err)
+	}
+
+	return &Client{inner: c}, nil
+}
+
+// Close releases the underlying Workload API connection.
+func (c *Client) Close() error {
+	return c.inner.Close()
+}
+
+// WatchSVIDs subscribes to the default X.509 SVID stream for this workload
+// and invokes onUpdate every time the SVID set changes, including on
+// rotation. WatchSVIDs blocks until ctx is cancelled, at which point it
+// returns ctx.Err().
+func (c *Client) WatchSVIDs(ctx context.Context, onUpdate func(*x509svid.SVID)) error {
+	watcher := &svidWatcher{onUpdate: onUpdate}
+	if err := c.inner.WatchX509Context(ctx, watcher); err != nil {
+		return fmt.Errorf("unable to watch SPIFFE X.509 context: %w", err)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// svidWatcher adapts our simple callback to the workloadapi.X509ContextWatcher
+// interface.
+type svidWatcher struct {
+	onUpdate func(*x509svid.SVID)
+}
+
+func (w *svidWatcher) OnX509ContextUpdate(c *workloadapi.X509Context) {
+	if len(c.SVIDs) == 0 {
+		return
+	}
+	// The default SVID is always the first entry, per the Workload API spec.
+	w.onUpdate(c.SVIDs[0])
+}
+
+func (w *svidWatcher) OnX509ContextWatchError(err error) {
+	if !strings.Contains(err.Error(), "context canceled") {
+		log.WithError(err).Warning("Error while watching SPIFFE X.509 context")
+	}
+}
+
+// LabelsFromID converts a SPIFFE ID into the set of labels that identify it
+// in Cilium's identity/ipcache layer. The primary label, `spiffe:id`, carries
+// the full SPIFFE ID URI as its value (e.g.
+// `spiffe:id=spiffe://example.org/ns/default/sa/default`) so that it matches
+// exactly what operators write into `toEndpoints`/`fromEndpoints` selectors
+// and what the `cilium endpoint get`/`cilium policy selectors` scenario
+// tests look for. `trust_domain` and `path` are split out as well for
+// selectors that only want to match part of the ID. All three use the
+// `spiffe:` label source defined in pkg/labels; selectors match it only
+// because CNP selectors are parsed generically as `source:key=value` and
+// this happens to be the same string, not because of any dedicated parser
+// support for SPIFFE.
+func LabelsFromID(id spiffeid.ID) labels.Labels {
+	lbls := labels.NewLabelsFromModel(nil)
+	lbls[labels.LabelSourceSpiffe+":id"] = labels.NewLabel("id", id.String(), labels.LabelSourceSpiffe)
+	lbls[labels.LabelSourceSpiffe+":trust_domain"] = labels.NewLabel("trust_domain", id.TrustDomain().String(), labels.LabelSourceSpiffe)
+	lbls[labels.LabelSourceSpiffe+":path"] = labels.NewLabel("path", id.Path(), labels.LabelSourceSpiffe)
+	return lbls
+}
+
+// IDFromSVID returns the SPIFFE ID embedded in the given X.509 SVID.
+func IDFromSVID(svid *x509svid.SVID) spiffeid.ID {
+	return svid.ID
+}
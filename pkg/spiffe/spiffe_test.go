@@ -0,0 +1,54 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spiffe
+
+import (
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+
+	"github.com/cilium/cilium/pkg/labels"
+)
+
+func TestLabelsFromID(t *testing.T) {
+	const rawID = "spiffe://example.org/ns/default/sa/default"
+	id := spiffeid.RequireFromString(rawID)
+
+	lbls := LabelsFromID(id)
+
+	idLabel, ok := lbls[labels.LabelSourceSpiffe+":id"]
+	if !ok {
+		t.Fatalf("expected id label to be present, got %+v", lbls)
+	}
+	if idLabel.Value != rawID {
+		t.Errorf("expected id value %q, got %q", rawID, idLabel.Value)
+	}
+
+	trustDomain, ok := lbls[labels.LabelSourceSpiffe+":trust_domain"]
+	if !ok {
+		t.Fatalf("expected trust_domain label to be present, got %+v", lbls)
+	}
+	if trustDomain.Value != "example.org" {
+		t.Errorf("expected trust_domain value %q, got %q", "example.org", trustDomain.Value)
+	}
+
+	path, ok := lbls[labels.LabelSourceSpiffe+":path"]
+	if !ok {
+		t.Fatalf("expected path label to be present, got %+v", lbls)
+	}
+	if path.Value != "/ns/default/sa/default" {
+		t.Errorf("expected path value %q, got %q", "/ns/default/sa/default", path.Value)
+	}
+}
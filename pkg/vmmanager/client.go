@@ -0,0 +1,112 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Client talks to the VM registration API exposed by clustermesh-apiserver's
+// NewHandler. The Cilium agent uses List to learn about registered VMs and
+// the identity the cluster-wide clustermesh-apiserver Manager handed out for
+// each, without running a Manager of its own; it passes that identity to its
+// identity allocator as the one to restore. The VM agent (vm-agent) uses
+// Register and Heartbeat to keep its own registration alive.
+type Client struct {
+	addr       string
+	httpClient *http.Client
+}
+
+// NewClient returns a Client that reads the VM registration API at addr,
+// e.g. "http://clustermesh-apiserver.kube-system.svc:9999".
+func NewClient(addr string) *Client {
+	return &Client{
+		addr:       addr,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// List returns every VM currently known to clustermesh-apiserver.
+func (c *Client) List(ctx context.Context) ([]*VM, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+"/list", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach VM registration API at %s: %w", c.addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("VM registration API returned %s", resp.Status)
+	}
+
+	var vms []*VM
+	if err := json.NewDecoder(resp.Body).Decode(&vms); err != nil {
+		return nil, fmt.Errorf("unable to decode VM registration API response: %w", err)
+	}
+	return vms, nil
+}
+
+// Register registers or refreshes req with clustermesh-apiserver. It is
+// used by the VM agent to make itself known, and counts as this VM's first
+// heartbeat.
+func (c *Client) Register(ctx context.Context, req RegisterRequest) (*VM, error) {
+	var vm VM
+	if err := c.post(ctx, "/register", req, &vm); err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
+
+// Heartbeat proves liveness for the VM named in req. It must be called
+// periodically, well within DefaultHeartbeatTimeout, or clustermesh-apiserver
+// will prune the VM and tear down its lxcmap/ipcache entries.
+func (c *Client) Heartbeat(ctx context.Context, req HeartbeatRequest) error {
+	return c.post(ctx, "/heartbeat", req, nil)
+}
+
+func (c *Client) post(ctx context.Context, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach VM registration API at %s: %w", c.addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("VM registration API returned %s", resp.Status)
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
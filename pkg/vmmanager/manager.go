@@ -0,0 +1,321 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmmanager
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "vmmanager")
+
+const (
+	// MinIdentity is the first numeric identity handed out to a VM, taken
+	// from the range pkg/identity reserves for external workloads.
+	MinIdentity = identity.MinExternalWorkloadIdentity
+
+	// MaxIdentity is the last numeric identity available to VMs.
+	MaxIdentity = identity.MaxExternalWorkloadIdentity
+
+	// DefaultHeartbeatTimeout is how long a VM may go without a heartbeat
+	// before it is considered stale and garbage collected.
+	DefaultHeartbeatTimeout = 3 * time.Minute
+)
+
+// Listener is notified of changes to the VM registry so that callers can
+// keep derived state, such as lxcmap/ipcache entries, in sync.
+type Listener interface {
+	// VMUpserted is called when a VM registers or refreshes its
+	// registration.
+	VMUpserted(vm *VM)
+
+	// VMRemoved is called when a VM is explicitly removed, or garbage
+	// collected after missing too many heartbeats.
+	VMRemoved(vm *VM)
+}
+
+// labelIdentity tracks the numeric identity shared by every VM currently
+// registered with a given label set, and how many VMs are referencing it, so
+// that the identity can be freed for reuse once the last VM with that label
+// set goes away. Deriving the identity from the label set, rather than from
+// the VM itself, means two VMs with identical labels share one
+// policy-selectable identity, the same way two pods with identical labels
+// do.
+type labelIdentity struct {
+	id       identity.NumericIdentity
+	refCount int
+}
+
+// Manager tracks the set of registered external VM workloads, allocates
+// security identities for their label sets out of the range reserved in
+// pkg/identity, and garbage collects VMs that stop sending heartbeats.
+type Manager struct {
+	mutex lock.Mutex
+
+	vms map[string]*VM
+
+	// identities maps a canonicalized label key (see labelKey) to the
+	// identity shared by every VM with that exact label set.
+	identities   map[string]*labelIdentity
+	nextIdentity identity.NumericIdentity
+
+	heartbeatTimeout time.Duration
+	listeners        []Listener
+}
+
+// NewManager returns an initialized Manager. A heartbeatTimeout of zero
+// selects DefaultHeartbeatTimeout.
+func NewManager(heartbeatTimeout time.Duration) *Manager {
+	if heartbeatTimeout == 0 {
+		heartbeatTimeout = DefaultHeartbeatTimeout
+	}
+
+	return &Manager{
+		vms:              map[string]*VM{},
+		identities:       map[string]*labelIdentity{},
+		nextIdentity:     MinIdentity,
+		heartbeatTimeout: heartbeatTimeout,
+	}
+}
+
+// AddListener registers l to be notified of future VM upserts and removals.
+func (m *Manager) AddListener(l Listener) {
+	m.mutex.Lock()
+	m.listeners = append(m.listeners, l)
+	m.mutex.Unlock()
+}
+
+// Register creates or refreshes the registration of the VM described by
+// req. The VM is allocated the identity shared by every other VM currently
+// registered with the same label set, allocating a new one out of the
+// reserved external-workload range the first time that label set is seen.
+func (m *Manager) Register(req RegisterRequest) (*VM, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("VM name must not be empty")
+	}
+
+	ips := make([]net.IP, 0, len(req.IPs))
+	for _, s := range req.IPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid VM IP address %q", s)
+		}
+		ips = append(ips, ip)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	vm, exists := m.vms[req.Name]
+	if exists {
+		if vm.Token != "" && vm.Token != req.Token {
+			return nil, fmt.Errorf("VM %q is already registered with a different token", req.Name)
+		}
+		m.releaseIdentityLocked(vm.Labels)
+	} else {
+		vm = &VM{Name: req.Name}
+		m.vms[req.Name] = vm
+	}
+
+	id, err := m.acquireIdentityLocked(req.Labels)
+	if err != nil {
+		if !exists {
+			delete(m.vms, req.Name)
+		}
+		return nil, err
+	}
+
+	vm.Labels = req.Labels
+	vm.IPs = ips
+	vm.Token = req.Token
+	vm.Identity = id
+	vm.LastHeartbeat = time.Now()
+
+	m.notifyUpsertLocked(vm)
+
+	return vm, nil
+}
+
+// Heartbeat refreshes the liveness timestamp of the VM named in req.
+func (m *Manager) Heartbeat(req HeartbeatRequest) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	vm, ok := m.vms[req.Name]
+	if !ok {
+		return fmt.Errorf("unknown VM %q", req.Name)
+	}
+	if vm.Token != "" && vm.Token != req.Token {
+		return fmt.Errorf("invalid token for VM %q", req.Name)
+	}
+
+	vm.LastHeartbeat = time.Now()
+	return nil
+}
+
+// Remove unregisters the named VM, if present, and notifies listeners.
+func (m *Manager) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	vm, ok := m.vms[name]
+	if !ok {
+		return fmt.Errorf("unknown VM %q", name)
+	}
+
+	delete(m.vms, name)
+	m.releaseIdentityLocked(vm.Labels)
+	m.notifyRemovedLocked(vm)
+
+	return nil
+}
+
+// List returns a snapshot of all currently registered VMs.
+func (m *Manager) List() []*VM {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	vms := make([]*VM, 0, len(m.vms))
+	for _, vm := range m.vms {
+		vms = append(vms, vm)
+	}
+	return vms
+}
+
+// PruneStale removes and returns all VMs whose last heartbeat is older than
+// the configured heartbeat timeout. It is intended to be invoked
+// periodically by a controller.
+func (m *Manager) PruneStale() []*VM {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deadline := time.Now().Add(-m.heartbeatTimeout)
+	var pruned []*VM
+
+	for name, vm := range m.vms {
+		if vm.LastHeartbeat.Before(deadline) {
+			delete(m.vms, name)
+			m.releaseIdentityLocked(vm.Labels)
+			m.notifyRemovedLocked(vm)
+			pruned = append(pruned, vm)
+			log.WithField(logfields.Identity, vm.Name).Info("Pruned stale VM after missing heartbeats")
+		}
+	}
+
+	return pruned
+}
+
+// labelKey canonicalizes a label set into a stable, comparable string so
+// that two VMs registering with the same labels in a different order still
+// share an identity.
+func labelKey(lbls map[string]string) string {
+	keys := make([]string, 0, len(lbls))
+	for k := range lbls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(lbls[k])
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// acquireIdentityLocked returns the identity for lbls, allocating a new one
+// out of the reserved range the first time this exact label set is seen.
+// The caller must hold m.mutex.
+func (m *Manager) acquireIdentityLocked(lbls map[string]string) (identity.NumericIdentity, error) {
+	key := labelKey(lbls)
+
+	if li, ok := m.identities[key]; ok {
+		li.refCount++
+		return li.id, nil
+	}
+
+	id, err := m.allocateIdentityLocked()
+	if err != nil {
+		return 0, err
+	}
+
+	m.identities[key] = &labelIdentity{id: id, refCount: 1}
+	return id, nil
+}
+
+// releaseIdentityLocked drops one reference to the identity allocated for
+// lbls, freeing it for reuse once no VM references it anymore. The caller
+// must hold m.mutex.
+func (m *Manager) releaseIdentityLocked(lbls map[string]string) {
+	key := labelKey(lbls)
+
+	li, ok := m.identities[key]
+	if !ok {
+		return
+	}
+
+	li.refCount--
+	if li.refCount <= 0 {
+		delete(m.identities, key)
+	}
+}
+
+// allocateIdentityLocked hands out the next free identity in the VM range.
+// The caller must hold m.mutex.
+func (m *Manager) allocateIdentityLocked() (identity.NumericIdentity, error) {
+	inUse := make(map[identity.NumericIdentity]struct{}, len(m.identities))
+	for _, li := range m.identities {
+		inUse[li.id] = struct{}{}
+	}
+
+	start := m.nextIdentity
+	for {
+		id := m.nextIdentity
+		m.nextIdentity++
+		if m.nextIdentity > MaxIdentity {
+			m.nextIdentity = MinIdentity
+		}
+
+		if _, taken := inUse[id]; !taken {
+			return id, nil
+		}
+
+		if m.nextIdentity == start {
+			return 0, fmt.Errorf("VM identity range [%d, %d] is exhausted", MinIdentity, MaxIdentity)
+		}
+	}
+}
+
+func (m *Manager) notifyUpsertLocked(vm *VM) {
+	for _, l := range m.listeners {
+		l.VMUpserted(vm)
+	}
+}
+
+func (m *Manager) notifyRemovedLocked(vm *VM) {
+	for _, l := range m.listeners {
+		l.VMRemoved(vm)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegisterAllocatesIdentity(t *testing.T) {
+	m := NewManager(time.Minute)
+
+	vm, err := m.Register(RegisterRequest{
+		Name:   "vm-1",
+		Labels: map[string]string{"app": "frontend"},
+		IPs:    []string{"10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vm.Identity != MinIdentity {
+		t.Errorf("expected first VM to get identity %d, got %d", MinIdentity, vm.Identity)
+	}
+
+	vm2, err := m.Register(RegisterRequest{
+		Name:   "vm-2",
+		Labels: map[string]string{"app": "backend"},
+		IPs:    []string{"10.0.0.2"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vm2.Identity == vm.Identity {
+		t.Errorf("expected distinct identities for distinct label sets, both got %d", vm.Identity)
+	}
+}
+
+func TestRegisterSharesIdentityForIdenticalLabels(t *testing.T) {
+	m := NewManager(time.Minute)
+
+	vm, err := m.Register(RegisterRequest{
+		Name:   "vm-1",
+		Labels: map[string]string{"app": "frontend", "env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	vm2, err := m.Register(RegisterRequest{
+		Name:   "vm-2",
+		Labels: map[string]string{"env": "prod", "app": "frontend"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if vm2.Identity != vm.Identity {
+		t.Errorf("expected VMs with identical labels to share an identity, got %d and %d", vm.Identity, vm2.Identity)
+	}
+
+	if err := m.Remove(vm.Name); err != nil {
+		t.Fatalf("unexpected error removing vm-1: %v", err)
+	}
+
+	vm3, err := m.Register(RegisterRequest{
+		Name:   "vm-3",
+		Labels: map[string]string{"app": "frontend", "env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vm3.Identity != vm.Identity {
+		t.Errorf("expected vm-3 to reuse the still-referenced identity %d, got %d", vm.Identity, vm3.Identity)
+	}
+}
+
+func TestRegisterRejectsWrongToken(t *testing.T) {
+	m := NewManager(time.Minute)
+
+	if _, err := m.Register(RegisterRequest{Name: "vm-1", Token: "secret"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := m.Register(RegisterRequest{Name: "vm-1", Token: "wrong"}); err == nil {
+		t.Fatalf("expected registration with mismatched token to fail")
+	}
+}
+
+func TestPruneStaleRemovesExpiredVMs(t *testing.T) {
+	m := NewManager(time.Millisecond)
+
+	vm, err := m.Register(RegisterRequest{Name: "vm-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	pruned := m.PruneStale()
+	if len(pruned) != 1 || pruned[0].Name != vm.Name {
+		t.Fatalf("expected vm-1 to be pruned, got %+v", pruned)
+	}
+
+	if len(m.List()) != 0 {
+		t.Errorf("expected registry to be empty after pruning, got %+v", m.List())
+	}
+}
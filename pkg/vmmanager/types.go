@@ -0,0 +1,66 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vmmanager implements registration and liveness tracking of
+// external VM workloads that join the cluster mesh without running the
+// full Cilium agent, e.g. bare VMs running only a lightweight VM agent that
+// registers itself against clustermesh-apiserver.
+package vmmanager
+
+import (
+	"net"
+	"time"
+
+	"github.com/cilium/cilium/pkg/identity"
+)
+
+// VM represents a single registered external workload.
+type VM struct {
+	// Name uniquely identifies the VM within the cluster.
+	Name string
+
+	// Labels are the labels associated with the VM, used for identity
+	// allocation and policy selection the same way pod labels are.
+	Labels map[string]string
+
+	// IPs are the IP addresses owned by the VM that should be resolvable
+	// to its allocated identity in the ipcache.
+	IPs []net.IP
+
+	// Token is an optional pre-shared token the VM agent must present on
+	// registration and on every heartbeat.
+	Token string
+
+	// Identity is the security identity allocated to this VM.
+	Identity identity.NumericIdentity
+
+	// LastHeartbeat is the time the most recent heartbeat was received.
+	LastHeartbeat time.Time
+}
+
+// RegisterRequest is the payload a VM agent sends to register itself or to
+// refresh its registration.
+type RegisterRequest struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	IPs    []string          `json:"ips"`
+	Token  string            `json:"token,omitempty"`
+}
+
+// HeartbeatRequest is the payload a VM agent sends periodically to prove
+// liveness.
+type HeartbeatRequest struct {
+	Name  string `json:"name"`
+	Token string `json:"token,omitempty"`
+}
@@ -126,11 +126,28 @@ var _ = SkipDescribeIf(func() bool {
 			).ExpectSuccess()
 		})
 
-		// Context("when the a spiffe workload is allowed to communicate only with spiffe another spiffe workload", func() {
-		// 	BeforeAll(func() {
-		// 		cnpSpiffeAllowDefault := helpers.ManifestGet(kubectl.BasePath(), "cnp-spiffe-allow-sa-default-ns-default.yaml")
-		// 		kubectl.ApplyDefault(cnpSpiffeAllowDefault).ExpectSuccess("Cannot import spiffe")
-		// 	})
-		// })
+		Context("when a spiffe workload is allowed to communicate only with another spiffe workload", func() {
+			var cnpSpiffeAllowDefault string
+
+			BeforeAll(func() {
+				cnpSpiffeAllowDefault = helpers.ManifestGet(kubectl.BasePath(), "cnp-spiffe-allow-sa-default-ns-default.yaml")
+				_, err := kubectl.CiliumPolicyAction(helpers.DefaultNamespace, cnpSpiffeAllowDefault, helpers.KubectlApply, helpers.HelperTimeout)
+				Expect(err).Should(BeNil(), "Cannot import policy selecting on spiffe identity")
+			})
+
+			AfterAll(func() {
+				_, err := kubectl.CiliumPolicyAction(helpers.DefaultNamespace, cnpSpiffeAllowDefault, helpers.KubectlDelete, helpers.HelperTimeout)
+				Expect(err).Should(BeNil(), "Cannot delete policy selecting on spiffe identity")
+			})
+
+			It("realizes the policy selector for the spiffe identity", func() {
+				ciliumPodK8s1, err := kubectl.GetCiliumPodOnNode(helpers.K8s1)
+				ExpectWithOffset(1, err).ShouldNot(HaveOccurred(), "Cannot determine cilium pod name")
+
+				cmd := fmt.Sprintf("cilium policy selectors -o json | grep -q '%s'", spiffeIdSADefaultNSDefault)
+				kubectl.CiliumExecContext(context.TODO(), ciliumPodK8s1, cmd).ExpectSuccess(
+					"Policy selector for %s was not realized by the agent", spiffeIdSADefaultNSDefault)
+			})
+		})
 	})
 })
@@ -0,0 +1,112 @@
+// Copyright 2020 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// vm-agent is the lightweight process a VM workload runs to register itself
+// with clustermesh-apiserver and keep its registration alive with periodic
+// heartbeats. Without it, a VM registered via `cilium vm add` has no
+// heartbeat sender and is garbage collected after
+// vmmanager.DefaultHeartbeatTimeout.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/vmmanager"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "vm-agent")
+
+func main() {
+	vmManagerAddr := flag.String("vm-manager-address", "http://localhost:9999", "Address of the clustermesh-apiserver VM registration API")
+	name := flag.String("name", "", "Name to register this VM under")
+	labels := flag.String("labels", "", "Comma-separated key=value labels to register this VM with")
+	ips := flag.String("ips", "", "Comma-separated IP addresses owned by this VM")
+	token := flag.String("token", "", "Pre-shared token to present on registration and heartbeats")
+	heartbeatInterval := flag.Duration("heartbeat-interval", vmmanager.DefaultHeartbeatTimeout/3, "How often to send a heartbeat; should be well below the server's heartbeat timeout")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatal("-name is required")
+	}
+
+	client := vmmanager.NewClient(*vmManagerAddr)
+	req := vmmanager.RegisterRequest{
+		Name:   *name,
+		Labels: parseLabels(*labels),
+		IPs:    splitNonEmpty(*ips),
+		Token:  *token,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	vm, err := client.Register(ctx, req)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to register VM")
+	}
+	log.WithField(logfields.Identity, vm.Identity).Infof("Registered VM %q", vm.Name)
+
+	ticker := time.NewTicker(*heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Shutting down, VM will be garbage collected once its heartbeat times out")
+			return
+		case <-ticker.C:
+			if err := client.Heartbeat(ctx, vmmanager.HeartbeatRequest{Name: *name, Token: *token}); err != nil {
+				log.WithError(err).Warning("Unable to send heartbeat")
+			}
+		}
+	}
+}
+
+func parseLabels(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+
+	lbls := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		lbls[parts[0]] = parts[1]
+	}
+	return lbls
+}
+
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}